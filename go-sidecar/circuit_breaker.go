@@ -1,237 +1,372 @@
-// This is a simplified implementation of the Go sidecar's circuit breaker pattern
+// Package main: circuit breaker implementation for the edge sidecar.
+//
+// This is a Closed/HalfOpen/Open state machine modeled on the well-known
+// sony/gobreaker design: rolling Counts drive a user-supplied ShouldTrip
+// predicate, and a resetTimeout gates the Open -> HalfOpen transition where
+// a bounded number of probe requests are allowed through before the breaker
+// fully closes or re-opens.
 package main
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
-	"log"
-	"net/http"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// CircuitBreaker implements the circuit breaker pattern to prevent cascading failures
-type CircuitBreaker struct {
-	name               string
-	failureThreshold   int           // Number of failures before opening the circuit
-	resetTimeout       time.Duration // Time to wait before attempting to close the circuit
-	requestTimeout     time.Duration // Request timeout
-	failureCount       int           // Current count of consecutive failures
-	lastFailureTime    time.Time     // Time of the last failure
-	state              State         // Current circuit state
-	mutex              sync.RWMutex  // Lock for thread safety
-	totalRequests      int           // Total requests processed
-	successfulRequests int           // Successful requests
-	failedRequests     int           // Failed requests
-	openedCount        int           // Number of times circuit has opened
-	lastStateChange    time.Time     // Time of the last state change
-	
-	// Metrics
-	requestsCounter    *prometheus.CounterVec
-	latencyHistogram   *prometheus.HistogramVec
-	circuitStateGauge  prometheus.Gauge
-}
-
-// State represents the circuit breaker state
+// State represents the circuit breaker state.
 type State int
 
 const (
-	Closed State = iota
-	HalfOpen
-	Open
+	StateClosed State = iota
+	StateHalfOpen
+	StateOpen
 )
 
-// NewCircuitBreaker creates a new circuit breaker
-func NewCircuitBreaker(name string, failureThreshold int, resetTimeout, requestTimeout time.Duration) *CircuitBreaker {
-	cb := &CircuitBreaker{
-		name:             name,
-		failureThreshold: failureThreshold,
-		resetTimeout:     resetTimeout,
-		requestTimeout:   requestTimeout,
-		state:            Closed,
-		lastStateChange:  time.Now(),
-	}
-	
-	// Initialize Prometheus metrics
-	cb.requestsCounter = promauto.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "circuit_breaker_requests_total",
-			Help: "The total number of requests processed by the circuit breaker",
-		},
-		[]string{"circuit", "result"},
-	)
-	
-	cb.latencyHistogram = promauto.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "circuit_breaker_request_duration_seconds",
-			Help:    "Request duration in seconds",
-			Buckets: prometheus.DefBuckets,
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+func (s State) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%q", s.String())), nil
+}
+
+// Counts holds the rolling request counters for a circuit breaker. They are
+// cleared every time the breaker changes state.
+type Counts struct {
+	Requests             uint32
+	TotalSuccesses       uint32
+	TotalFailures        uint32
+	ConsecutiveSuccesses uint32
+	ConsecutiveFailures  uint32
+}
+
+func (c *Counts) onRequest() {
+	c.Requests++
+}
+
+func (c *Counts) onSuccess() {
+	c.TotalSuccesses++
+	c.ConsecutiveSuccesses++
+	c.ConsecutiveFailures = 0
+}
+
+func (c *Counts) onFailure() {
+	c.TotalFailures++
+	c.ConsecutiveFailures++
+	c.ConsecutiveSuccesses = 0
+}
+
+func (c *Counts) clear() {
+	*c = Counts{}
+}
+
+// Per-circuit Prometheus metrics. Kept as package-level vectors (rather than
+// per-instance collectors) so that creating multiple named breakers - e.g.
+// one per route - doesn't trip Prometheus's duplicate-registration panic.
+var (
+	circuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "edge_sidecar_circuit_breaker_state",
+			Help: "Circuit breaker state (0=closed, 1=half-open, 2=open)",
 		},
 		[]string{"circuit"},
 	)
-	
-	cb.circuitStateGauge = promauto.NewGauge(
-		prometheus.GaugeOpts{
-			Name: fmt.Sprintf("circuit_breaker_%s_state", name),
-			Help: "Current state of the circuit breaker (0=closed, 1=half-open, 2=open)",
+	circuitBreakerTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_sidecar_circuit_breaker_transitions_total",
+			Help: "Total number of circuit breaker state transitions",
 		},
+		[]string{"circuit", "from", "to"},
 	)
-	
-	return cb
+)
+
+// ShouldTripFunc decides whether the breaker should open, given the rolling
+// counts accumulated since the last state change.
+type ShouldTripFunc func(counts Counts) bool
+
+// OnStateChangeFunc is invoked whenever a breaker transitions between states.
+type OnStateChangeFunc func(name string, from, to State)
+
+// CircuitBreakerSettings configures a CircuitBreaker. Zero-valued fields fall
+// back to sane defaults in NewCircuitBreaker.
+type CircuitBreakerSettings struct {
+	Name string
+
+	// ConsecutiveFailureThreshold opens the breaker once ConsecutiveFailures
+	// reaches this value. Ignored if ShouldTrip is set.
+	ConsecutiveFailureThreshold int
+	// FailureRatio and MinRequests open the breaker once Requests >=
+	// MinRequests and TotalFailures/Requests >= FailureRatio. Ignored if
+	// ShouldTrip is set. FailureRatio <= 0 disables ratio-based tripping.
+	FailureRatio float64
+	MinRequests  uint32
+	// ShouldTrip overrides the default threshold/ratio tripping logic.
+	ShouldTrip ShouldTripFunc
+
+	// ResetTimeout is how long the breaker stays Open before allowing a
+	// HalfOpen probe.
+	ResetTimeout time.Duration
+	// MaxHalfOpenRequests bounds how many probe requests are allowed in
+	// flight while HalfOpen; the breaker closes once that many succeed
+	// consecutively, or re-opens on the first failure.
+	MaxHalfOpenRequests uint32
+
+	OnStateChange OnStateChangeFunc
 }
 
-// Execute runs the given request if the circuit is closed or half-open
-func (cb *CircuitBreaker) Execute(req *http.Request, client *http.Client) (*http.Response, error) {
-	// Check if circuit is open
-	if !cb.AllowRequest() {
-		cb.requestsCounter.WithLabelValues(cb.name, "short_circuit").Inc()
-		return nil, fmt.Errorf("circuit breaker '%s' is open", cb.name)
-	}
-	
-	// Create timeout context
-	ctx, cancel := context.WithTimeout(req.Context(), cb.requestTimeout)
-	defer cancel()
-	req = req.WithContext(ctx)
-	
-	// Track request time
-	startTime := time.Now()
-	
-	// Execute the request
-	resp, err := client.Do(req)
-	
-	// Record metrics
-	duration := time.Since(startTime)
-	cb.latencyHistogram.WithLabelValues(cb.name).Observe(duration.Seconds())
-	
-	// Handle response
-	if err != nil || (resp != nil && resp.StatusCode >= 500) {
-		cb.recordFailure()
-		cb.requestsCounter.WithLabelValues(cb.name, "failure").Inc()
-		if err != nil {
-			return nil, err
+// CircuitBreaker implements the circuit breaker pattern to prevent cascading
+// failures against a failing upstream.
+type CircuitBreaker struct {
+	name                string
+	shouldTrip          ShouldTripFunc
+	onStateChange       OnStateChangeFunc
+	resetTimeout        time.Duration
+	maxHalfOpenRequests uint32
+
+	mutex            sync.Mutex
+	state            State
+	counts           Counts
+	openedAt         time.Time
+	lastStateChange  time.Time
+	halfOpenInFlight uint32
+	openedCount      uint32
+}
+
+// NewCircuitBreaker creates a new circuit breaker in the Closed state.
+func NewCircuitBreaker(settings CircuitBreakerSettings) *CircuitBreaker {
+	if settings.Name == "" {
+		settings.Name = "default"
+	}
+	if settings.MaxHalfOpenRequests == 0 {
+		settings.MaxHalfOpenRequests = 1
+	}
+	if settings.ResetTimeout <= 0 {
+		settings.ResetTimeout = 30 * time.Second
+	}
+
+	shouldTrip := settings.ShouldTrip
+	if shouldTrip == nil {
+		threshold := settings.ConsecutiveFailureThreshold
+		ratio := settings.FailureRatio
+		minRequests := settings.MinRequests
+		shouldTrip = func(counts Counts) bool {
+			if threshold > 0 && counts.ConsecutiveFailures >= uint32(threshold) {
+				return true
+			}
+			if ratio > 0 && counts.Requests >= minRequests && minRequests > 0 {
+				if float64(counts.TotalFailures)/float64(counts.Requests) >= ratio {
+					return true
+				}
+			}
+			return false
 		}
-		return resp, nil
 	}
-	
-	// Success
-	cb.recordSuccess()
-	cb.requestsCounter.WithLabelValues(cb.name, "success").Inc()
-	return resp, nil
+
+	cb := &CircuitBreaker{
+		name:                settings.Name,
+		shouldTrip:          shouldTrip,
+		onStateChange:       settings.OnStateChange,
+		resetTimeout:        settings.ResetTimeout,
+		maxHalfOpenRequests: settings.MaxHalfOpenRequests,
+		state:               StateClosed,
+		lastStateChange:     time.Now(),
+	}
+	circuitBreakerState.WithLabelValues(cb.name).Set(float64(StateClosed))
+	registerCircuitBreaker(cb)
+	return cb
 }
 
-// AllowRequest determines if a request should be allowed based on the circuit state
-func (cb *CircuitBreaker) AllowRequest() bool {
-	cb.mutex.RLock()
-	defer cb.mutex.RUnlock()
-	
+// Allow reports whether a request should be let through. While Open it
+// returns false until resetTimeout has elapsed, at which point the breaker
+// promotes to HalfOpen and admits up to maxHalfOpenRequests probes.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.maybePromoteFromOpen(time.Now())
+
 	switch cb.state {
-	case Closed:
-		return true
-	case Open:
-		// Check if reset timeout has expired
-		if time.Since(cb.lastFailureTime) > cb.resetTimeout {
-			// Move to half-open state
-			cb.mutex.RUnlock()
-			cb.mutex.Lock()
-			if cb.state == Open {
-				cb.state = HalfOpen
-				cb.updateStateMetric()
-				cb.lastStateChange = time.Now()
-				log.Printf("Circuit '%s' state changed from Open to Half-Open", cb.name)
-			}
-			cb.mutex.Unlock()
-			cb.mutex.RLock()
-			return true
-		}
+	case StateOpen:
 		return false
-	case HalfOpen:
-		// In half-open state, only allow a limited number of requests through
-		// Here we implement a simple strategy: allow 1 request per second
-		return time.Since(cb.lastStateChange).Seconds() > float64(cb.totalRequests%10)
+	case StateHalfOpen:
+		if cb.halfOpenInFlight >= cb.maxHalfOpenRequests {
+			return false
+		}
+		cb.halfOpenInFlight++
+		return true
 	default:
 		return true
 	}
 }
 
-// recordSuccess records a successful request
-func (cb *CircuitBreaker) recordSuccess() {
+// RecordSuccess records a successful request against the breaker.
+func (cb *CircuitBreaker) RecordSuccess() {
 	cb.mutex.Lock()
 	defer cb.mutex.Unlock()
-	
-	cb.totalRequests++
-	cb.successfulRequests++
-	
-	// If in half-open state and we've had enough successes, close the circuit
-	if cb.state == HalfOpen && cb.successfulRequests > cb.failureThreshold {
-		cb.state = Closed
-		cb.failureCount = 0
-		cb.updateStateMetric()
-		cb.lastStateChange = time.Now()
-		log.Printf("Circuit '%s' state changed from Half-Open to Closed", cb.name)
-	}
-}
-
-// recordFailure records a failed request
-func (cb *CircuitBreaker) recordFailure() {
+
+	wasHalfOpen := cb.state == StateHalfOpen
+	cb.counts.onRequest()
+	cb.counts.onSuccess()
+
+	switch cb.state {
+	case StateHalfOpen:
+		if wasHalfOpen && cb.halfOpenInFlight > 0 {
+			cb.halfOpenInFlight--
+		}
+		if cb.counts.ConsecutiveSuccesses >= cb.maxHalfOpenRequests {
+			cb.transitionTo(StateClosed)
+		}
+	case StateClosed:
+		// Stay closed; ConsecutiveFailures already reset by onSuccess.
+	}
+}
+
+// RecordFailure records a failed request against the breaker.
+func (cb *CircuitBreaker) RecordFailure() {
 	cb.mutex.Lock()
 	defer cb.mutex.Unlock()
-	
-	cb.totalRequests++
-	cb.failedRequests++
-	cb.failureCount++
-	cb.lastFailureTime = time.Now()
-	
-	// If we've reached the failure threshold, open the circuit
-	if (cb.state == Closed && cb.failureCount >= cb.failureThreshold) ||
-	   (cb.state == HalfOpen) {
-		cb.state = Open
-		cb.openedCount++
-		cb.updateStateMetric()
-		cb.lastStateChange = time.Now()
-		log.Printf("Circuit '%s' state changed to Open", cb.name)
+
+	wasHalfOpen := cb.state == StateHalfOpen
+	cb.counts.onRequest()
+	cb.counts.onFailure()
+
+	switch cb.state {
+	case StateHalfOpen:
+		if wasHalfOpen && cb.halfOpenInFlight > 0 {
+			cb.halfOpenInFlight--
+		}
+		cb.transitionTo(StateOpen)
+	case StateClosed:
+		if cb.shouldTrip(cb.counts) {
+			cb.transitionTo(StateOpen)
+		}
 	}
 }
 
-// updateStateMetric updates the Prometheus gauge with the current circuit state
-func (cb *CircuitBreaker) updateStateMetric() {
-	cb.circuitStateGauge.Set(float64(cb.state))
+// maybePromoteFromOpen moves an Open breaker to HalfOpen once resetTimeout
+// has elapsed. Callers must hold cb.mutex.
+func (cb *CircuitBreaker) maybePromoteFromOpen(now time.Time) {
+	if cb.state == StateOpen && now.Sub(cb.openedAt) >= cb.resetTimeout {
+		cb.transitionTo(StateHalfOpen)
+	}
 }
 
-// GetState returns the current state of the circuit breaker
+// ForceHalfOpen short-circuits resetTimeout, promoting an Open breaker to
+// HalfOpen immediately. Used by the active health prober on upstream
+// recovery.
+func (cb *CircuitBreaker) ForceHalfOpen() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if cb.state == StateOpen {
+		cb.transitionTo(StateHalfOpen)
+	}
+}
+
+// ForceOpen pre-emptively opens a Closed breaker, e.g. on repeated active
+// health probe failures.
+func (cb *CircuitBreaker) ForceOpen() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if cb.state == StateClosed {
+		cb.transitionTo(StateOpen)
+	}
+}
+
+// transitionTo changes state, clears rolling counts, and fires hooks.
+// Callers must hold cb.mutex.
+func (cb *CircuitBreaker) transitionTo(state State) {
+	if cb.state == state {
+		return
+	}
+	from := cb.state
+	cb.state = state
+	cb.counts.clear()
+	cb.halfOpenInFlight = 0
+	cb.lastStateChange = time.Now()
+	if state == StateOpen {
+		cb.openedAt = cb.lastStateChange
+		cb.openedCount++
+	}
+
+	circuitBreakerState.WithLabelValues(cb.name).Set(float64(state))
+	circuitBreakerTransitions.WithLabelValues(cb.name, from.String(), state.String()).Inc()
+
+	if cb.onStateChange != nil {
+		cb.onStateChange(cb.name, from, state)
+	}
+}
+
+// GetState returns the current state of the circuit breaker.
 func (cb *CircuitBreaker) GetState() State {
-	cb.mutex.RLock()
-	defer cb.mutex.RUnlock()
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.maybePromoteFromOpen(time.Now())
 	return cb.state
 }
 
-// GetStats returns statistics about the circuit breaker
-func (cb *CircuitBreaker) GetStats() map[string]interface{} {
-	cb.mutex.RLock()
-	defer cb.mutex.RUnlock()
-	
-	stats := map[string]interface{}{
-		"name":               cb.name,
-		"state":              cb.state,
-		"failure_threshold":  cb.failureThreshold,
-		"reset_timeout_ms":   cb.resetTimeout.Milliseconds(),
-		"failure_count":      cb.failureCount,
-		"total_requests":     cb.totalRequests,
-		"successful_requests": cb.successfulRequests,
-		"failed_requests":    cb.failedRequests,
-		"opened_count":       cb.openedCount,
-		"time_since_last_state_change_ms": time.Since(cb.lastStateChange).Milliseconds(),
-	}
-	
-	if !cb.lastFailureTime.IsZero() {
-		stats["time_since_last_failure_ms"] = time.Since(cb.lastFailureTime).Milliseconds()
-	}
-	
-	return stats
+// BreakerStats is the JSON shape returned by the /breakers admin endpoint.
+type BreakerStats struct {
+	Name                 string `json:"name"`
+	State                State  `json:"state"`
+	Requests             uint32 `json:"requests"`
+	TotalSuccesses       uint32 `json:"total_successes"`
+	TotalFailures        uint32 `json:"total_failures"`
+	ConsecutiveSuccesses uint32 `json:"consecutive_successes"`
+	ConsecutiveFailures  uint32 `json:"consecutive_failures"`
+	OpenedCount          uint32 `json:"opened_count"`
+	LastStateChangeMs    int64  `json:"last_state_change_ms_ago"`
 }
 
-// Rest of the implementation would continue with API handler functions that use this circuit breaker
+// GetStats returns a snapshot of the breaker's counts and state, used by the
+// /breakers admin endpoint.
+func (cb *CircuitBreaker) GetStats() BreakerStats {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.maybePromoteFromOpen(time.Now())
+	return BreakerStats{
+		Name:                 cb.name,
+		State:                cb.state,
+		Requests:             cb.counts.Requests,
+		TotalSuccesses:       cb.counts.TotalSuccesses,
+		TotalFailures:        cb.counts.TotalFailures,
+		ConsecutiveSuccesses: cb.counts.ConsecutiveSuccesses,
+		ConsecutiveFailures:  cb.counts.ConsecutiveFailures,
+		OpenedCount:          cb.openedCount,
+		LastStateChangeMs:    time.Since(cb.lastStateChange).Milliseconds(),
+	}
+}
+
+// breakerRegistry tracks every CircuitBreaker created by the process so the
+// /breakers admin endpoint can report on all of them (the global breaker and
+// each per-route breaker).
+var breakerRegistry struct {
+	mutex sync.Mutex
+	all   []*CircuitBreaker
+}
+
+func registerCircuitBreaker(cb *CircuitBreaker) {
+	breakerRegistry.mutex.Lock()
+	defer breakerRegistry.mutex.Unlock()
+	breakerRegistry.all = append(breakerRegistry.all, cb)
+}
+
+func allBreakerStats() []BreakerStats {
+	breakerRegistry.mutex.Lock()
+	defer breakerRegistry.mutex.Unlock()
+	stats := make([]BreakerStats, 0, len(breakerRegistry.all))
+	for _, cb := range breakerRegistry.all {
+		stats = append(stats, cb.GetStats())
+	}
+	return stats
+}
@@ -0,0 +1,133 @@
+// Package main: error classification for the proxy's upstream call,
+// distinguishing client-side cancellation from genuine upstream failures so
+// that a slow client can't trip the circuit breaker on the upstream's
+// behalf.
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// FailureClass buckets the possible outcomes of an upstream call gone wrong,
+// mirroring the error classes vulcand/oxy-style proxies distinguish.
+type FailureClass int
+
+const (
+	// FailureClassNone means the call did not fail.
+	FailureClassNone FailureClass = iota
+	// FailureClassClientCanceled means the client disconnected or its
+	// request context was canceled before the upstream responded.
+	FailureClassClientCanceled
+	// FailureClassTimeout means our own deadline (context.DeadlineExceeded
+	// or a net.Error reporting Timeout()) elapsed.
+	FailureClassTimeout
+	// FailureClassDialError means we couldn't establish a connection to
+	// the upstream at all.
+	FailureClassDialError
+	// FailureClassConnectionError covers everything else: EOF, connection
+	// reset, and other mid-flight transport failures.
+	FailureClassConnectionError
+)
+
+func (f FailureClass) String() string {
+	switch f {
+	case FailureClassNone:
+		return "none"
+	case FailureClassClientCanceled:
+		return "client_canceled"
+	case FailureClassTimeout:
+		return "timeout"
+	case FailureClassDialError:
+		return "dial_error"
+	case FailureClassConnectionError:
+		return "connection_error"
+	default:
+		return "unknown"
+	}
+}
+
+// statusClosedRequest is the non-standard Nginx/vulcand status for a client
+// that disconnected before the response was ready.
+const statusClosedRequest = 499
+
+// StatusCode maps a failure class to the HTTP status returned to the client.
+func (f FailureClass) StatusCode() int {
+	switch f {
+	case FailureClassClientCanceled:
+		return statusClosedRequest
+	case FailureClassTimeout:
+		return http.StatusGatewayTimeout
+	case FailureClassDialError:
+		return http.StatusServiceUnavailable
+	case FailureClassConnectionError:
+		return http.StatusBadGateway
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// StatusText returns a human-readable reason phrase, since 499 has no entry
+// in net/http's table.
+func (f FailureClass) StatusText() string {
+	if f == FailureClassClientCanceled {
+		return "Client Closed Request"
+	}
+	return http.StatusText(f.StatusCode())
+}
+
+// CountsAsUpstreamFailure reports whether this class should feed the
+// circuit breaker. Client cancellations must not: a client that keeps
+// hanging up early would otherwise trip the breaker for everyone.
+func (f FailureClass) CountsAsUpstreamFailure() bool {
+	return f == FailureClassTimeout || f == FailureClassDialError || f == FailureClassConnectionError
+}
+
+// ClassifyError buckets an error returned by http.Client.Do into a
+// FailureClass. reqCtx is the original incoming request's context; it's
+// consulted to confirm a context.Canceled error actually originates from
+// the client disconnecting, rather than from our own plumbing.
+func ClassifyError(err error, reqCtx context.Context) FailureClass {
+	if err == nil {
+		return FailureClassNone
+	}
+
+	if errors.Is(err, context.Canceled) && reqCtx.Err() == context.Canceled {
+		return FailureClassClientCanceled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return FailureClassTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return FailureClassTimeout
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return FailureClassDialError
+	}
+
+	if errors.Is(err, io.EOF) {
+		return FailureClassConnectionError
+	}
+
+	return FailureClassConnectionError
+}
+
+// errorClassTotal counts proxy errors by classification, labeled so
+// operators can tell client-side disconnects apart from genuine upstream
+// trouble.
+var errorClassTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "edge_sidecar_proxy_errors_total",
+		Help: "Total number of proxy errors by classification",
+	},
+	[]string{"error_class"},
+)
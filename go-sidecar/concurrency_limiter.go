@@ -0,0 +1,173 @@
+// Package main: adaptive concurrency limiting in front of the proxy
+// handler. This complements the circuit breaker by shedding load before the
+// upstream begins failing outright: a semaphore-style in-flight count is
+// gated against a limit that, in adaptive mode, is adjusted AIMD-style
+// (Netflix concurrency-limits-inspired) - halved when rolling p95 latency
+// degrades beyond a configurable multiple of the observed minimum, and
+// additively increased by one whenever the limit is saturated and latency
+// stays healthy.
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	inflightGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "edge_sidecar_inflight",
+			Help: "Current number of in-flight requests admitted past the concurrency limiter",
+		},
+	)
+	limitGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "edge_sidecar_limit",
+			Help: "Current concurrency limit enforced by the limiter",
+		},
+	)
+	rejectedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "edge_sidecar_rejected_total",
+			Help: "Total number of requests rejected by the concurrency limiter",
+		},
+	)
+)
+
+// ConcurrencyLimiterSettings configures a ConcurrencyLimiter.
+type ConcurrencyLimiterSettings struct {
+	// MaxInflight is the limiter's starting point. In static mode it's the
+	// limit forever; in adaptive mode it's also the ceiling the limit can
+	// grow back up to.
+	MaxInflight int
+	// Adaptive enables AIMD adjustment of the limit. If false, MaxInflight
+	// is enforced unconditionally.
+	Adaptive bool
+	// MinLimit bounds how low a multiplicative decrease can take the
+	// limit.
+	MinLimit int
+	// LatencyDegradationMultiple triggers a multiplicative decrease once
+	// rolling p95 latency exceeds this multiple of the observed minimum
+	// latency.
+	LatencyDegradationMultiple float64
+	// SampleWindow bounds how many recent request latencies the rolling
+	// p95 is computed over.
+	SampleWindow int
+}
+
+// ConcurrencyLimiter gates concurrent requests with an in-flight counter.
+type ConcurrencyLimiter struct {
+	settings ConcurrencyLimiterSettings
+
+	mutex    sync.Mutex
+	inflight int
+	limit    int
+	minRTT   time.Duration
+	samples  []time.Duration
+}
+
+// NewConcurrencyLimiter creates a limiter starting at settings.MaxInflight.
+func NewConcurrencyLimiter(settings ConcurrencyLimiterSettings) *ConcurrencyLimiter {
+	if settings.MaxInflight <= 0 {
+		settings.MaxInflight = 1
+	}
+	if settings.MinLimit <= 0 {
+		settings.MinLimit = 1
+	}
+	if settings.LatencyDegradationMultiple <= 0 {
+		settings.LatencyDegradationMultiple = 2
+	}
+	if settings.SampleWindow <= 0 {
+		settings.SampleWindow = 100
+	}
+
+	l := &ConcurrencyLimiter{
+		settings: settings,
+		limit:    settings.MaxInflight,
+	}
+	limitGauge.Set(float64(l.limit))
+	return l
+}
+
+// Acquire reports whether a request may proceed, incrementing the in-flight
+// count if so. Every Acquire that returns true must be paired with a
+// Release.
+func (l *ConcurrencyLimiter) Acquire() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.inflight >= l.limit {
+		rejectedTotal.Inc()
+		return false
+	}
+	l.inflight++
+	inflightGauge.Set(float64(l.inflight))
+	return true
+}
+
+// Release records latency and, in adaptive mode, adjusts the limit before
+// decrementing the in-flight count.
+func (l *ConcurrencyLimiter) Release(latency time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.settings.Adaptive {
+		l.recordSample(latency)
+		l.maybeAdjustLimit()
+	}
+
+	l.inflight--
+	inflightGauge.Set(float64(l.inflight))
+}
+
+// recordSample folds latency into the observed minimum and the rolling
+// sample window. Callers must hold l.mutex.
+func (l *ConcurrencyLimiter) recordSample(latency time.Duration) {
+	if l.minRTT == 0 || latency < l.minRTT {
+		l.minRTT = latency
+	}
+	l.samples = append(l.samples, latency)
+	if len(l.samples) > l.settings.SampleWindow {
+		l.samples = l.samples[len(l.samples)-l.settings.SampleWindow:]
+	}
+}
+
+// maybeAdjustLimit applies AIMD: halve the limit once rolling p95 latency
+// degrades beyond LatencyDegradationMultiple times the observed minimum;
+// otherwise additively increase it by one once the limit is saturated and
+// latency looks healthy. A decrease clears the sample window so the next
+// decrease requires a fresh window of post-halving samples, rather than
+// re-tripping on every subsequent Release against the same degraded
+// samples that already justified one decrease. Callers must hold l.mutex.
+func (l *ConcurrencyLimiter) maybeAdjustLimit() {
+	if len(l.samples) < l.settings.SampleWindow || l.minRTT == 0 {
+		return
+	}
+
+	p95 := percentile(l.samples, 0.95)
+	if float64(p95) > float64(l.minRTT)*l.settings.LatencyDegradationMultiple {
+		newLimit := l.limit / 2
+		if newLimit < l.settings.MinLimit {
+			newLimit = l.settings.MinLimit
+		}
+		l.limit = newLimit
+		l.samples = l.samples[:0]
+	} else if l.inflight >= l.limit && l.limit < l.settings.MaxInflight {
+		l.limit++
+	}
+
+	limitGauge.Set(float64(l.limit))
+}
+
+// percentile returns the pth percentile (0-1) of samples, which is copied
+// before sorting so the caller's ordering isn't disturbed.
+func percentile(samples []time.Duration, p float64) time.Duration {
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(p * float64(len(sorted)-1))
+	return sorted[idx]
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiterRejectsOverCapacity(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyLimiterSettings{MaxInflight: 2})
+
+	if !l.Acquire() {
+		t.Fatal("Expected first Acquire to succeed")
+	}
+	if !l.Acquire() {
+		t.Fatal("Expected second Acquire to succeed")
+	}
+	if l.Acquire() {
+		t.Fatal("Expected third Acquire to be rejected at capacity 2")
+	}
+
+	l.Release(time.Millisecond)
+	if !l.Acquire() {
+		t.Fatal("Expected Acquire to succeed again after a Release")
+	}
+}
+
+func TestConcurrencyLimiterStaticModeNeverAdjustsLimit(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyLimiterSettings{MaxInflight: 4, SampleWindow: 2})
+
+	for i := 0; i < 10; i++ {
+		l.Acquire()
+		l.Release(time.Duration(i+1) * 100 * time.Millisecond)
+	}
+
+	if l.limit != 4 {
+		t.Fatalf("Expected static mode to leave the limit at 4, got %d", l.limit)
+	}
+}
+
+func TestConcurrencyLimiterAdaptiveModeHalvesLimitOnLatencyDegradation(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyLimiterSettings{
+		MaxInflight:                10,
+		Adaptive:                   true,
+		MinLimit:                   1,
+		LatencyDegradationMultiple: 2,
+		SampleWindow:               4,
+	})
+
+	// Establish a healthy minimum latency.
+	for i := 0; i < 4; i++ {
+		l.Acquire()
+		l.Release(10 * time.Millisecond)
+	}
+	if l.limit != 10 {
+		t.Fatalf("Expected the limit to stay at 10 while latency is healthy, got %d", l.limit)
+	}
+
+	// Latency degrades well beyond 2x the observed minimum.
+	for i := 0; i < 4; i++ {
+		l.Acquire()
+		l.Release(100 * time.Millisecond)
+	}
+	if l.limit != 5 {
+		t.Fatalf("Expected the limit to halve to 5 on latency degradation, got %d", l.limit)
+	}
+}
+
+func TestConcurrencyLimiterAdaptiveModeIncreasesWhenSaturatedAndHealthy(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyLimiterSettings{
+		MaxInflight:                4,
+		Adaptive:                   true,
+		MinLimit:                   1,
+		LatencyDegradationMultiple: 2,
+		SampleWindow:               2,
+	})
+
+	// The limit starts at MaxInflight, so halve it first to leave room to grow.
+	for _, latency := range []time.Duration{10 * time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond} {
+		l.Acquire()
+		l.Release(latency)
+	}
+	if l.limit != 2 {
+		t.Fatalf("Expected the limit to halve to 2 before the saturation check, got %d", l.limit)
+	}
+
+	// One healthy sample, then release while saturated (inflight == limit)
+	// so the sample window is full when the limit is checked.
+	l.Acquire()
+	l.Release(10 * time.Millisecond)
+	l.Acquire()
+	l.Acquire()
+	l.Release(10 * time.Millisecond)
+
+	if l.limit < 3 {
+		t.Fatalf("Expected the limit to grow from saturation, got %d", l.limit)
+	}
+}
+
+func TestConcurrencyLimiterAdaptiveModeNeverGrowsPastMaxInflight(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyLimiterSettings{
+		MaxInflight:                3,
+		Adaptive:                   true,
+		MinLimit:                   1,
+		LatencyDegradationMultiple: 2,
+		SampleWindow:               1,
+	})
+
+	for i := 0; i < 20; i++ {
+		l.Acquire()
+		l.Release(time.Millisecond)
+	}
+
+	if l.limit > 3 {
+		t.Fatalf("Expected the limit to never exceed MaxInflight 3, got %d", l.limit)
+	}
+}
+
+func TestConcurrencyLimiterNeverDecreasesBelowMinLimit(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyLimiterSettings{
+		MaxInflight:                8,
+		Adaptive:                   true,
+		MinLimit:                   2,
+		LatencyDegradationMultiple: 2,
+		SampleWindow:               2,
+	})
+
+	l.Acquire()
+	l.Release(time.Millisecond)
+	l.Acquire()
+	l.Release(time.Millisecond)
+
+	// Repeatedly degrade latency; the limit should never drop below MinLimit.
+	for i := 0; i < 10; i++ {
+		l.Acquire()
+		l.Release(time.Second)
+	}
+
+	if l.limit < 2 {
+		t.Fatalf("Expected the limit to never drop below MinLimit 2, got %d", l.limit)
+	}
+}
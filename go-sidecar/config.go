@@ -0,0 +1,101 @@
+// Package main: the per-route --config file. A SIGHUP reload respawns the
+// process (see reload.go), and the respawned process re-reads this file at
+// startup like any other flag - so "reloadable on SIGHUP" falls out of the
+// existing reload mechanism for free, with no separate hot-reload path to
+// keep in sync with it.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/robert-nguyenn/edge_control_platform/go-sidecar/internal/routing"
+	"gopkg.in/yaml.v3"
+)
+
+// RouteConfig is one entry in the --config file's routes list. Timeout and
+// Breaker.Reset are parsed as Go duration strings (e.g. "2s").
+type RouteConfig struct {
+	Name    string             `yaml:"name" json:"name"`
+	Match   string             `yaml:"match" json:"match"`
+	Timeout string             `yaml:"timeout" json:"timeout"`
+	Retries int                `yaml:"retries" json:"retries"`
+	Methods []string           `yaml:"methods" json:"methods"`
+	Breaker RouteBreakerConfig `yaml:"breaker" json:"breaker"`
+}
+
+// RouteBreakerConfig is a route's circuit breaker overrides.
+type RouteBreakerConfig struct {
+	Threshold int    `yaml:"threshold" json:"threshold"`
+	Reset     string `yaml:"reset" json:"reset"`
+}
+
+// Config is the top-level --config file shape.
+type Config struct {
+	Routes []RouteConfig `yaml:"routes" json:"routes"`
+}
+
+// loadConfig reads and parses path as YAML, or as JSON if path ends in
+// ".json". An empty path returns a Config with no routes, so --config is
+// optional.
+func loadConfig(path string) (*Config, error) {
+	if path == "" {
+		return &Config{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+
+	var cfg Config
+	if strings.HasSuffix(path, ".json") {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("parsing json config %s: %w", path, err)
+		}
+	} else {
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("parsing yaml config %s: %w", path, err)
+		}
+	}
+	return &cfg, nil
+}
+
+// routingRules converts the parsed config into routing.Rule values,
+// resolving each duration string up front so RouteRegistry.Load never has to
+// handle a parse error mid-reload.
+func (c *Config) routingRules() ([]routing.Rule, error) {
+	rules := make([]routing.Rule, 0, len(c.Routes))
+	for _, rc := range c.Routes {
+		var timeout time.Duration
+		if rc.Timeout != "" {
+			var err error
+			timeout, err = time.ParseDuration(rc.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("route %q: invalid timeout %q: %w", rc.Name, rc.Timeout, err)
+			}
+		}
+
+		var reset time.Duration
+		if rc.Breaker.Reset != "" {
+			var err error
+			reset, err = time.ParseDuration(rc.Breaker.Reset)
+			if err != nil {
+				return nil, fmt.Errorf("route %q: invalid breaker reset %q: %w", rc.Name, rc.Breaker.Reset, err)
+			}
+		}
+
+		rules = append(rules, routing.Rule{
+			Name:    rc.Name,
+			Match:   rc.Match,
+			Timeout: timeout,
+			Retries: rc.Retries,
+			Methods: rc.Methods,
+			Breaker: routing.BreakerConfig{Threshold: rc.Breaker.Threshold, Reset: reset},
+		})
+	}
+	return rules, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigParsesYAMLRoutes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	yaml := `
+routes:
+  - name: payments
+    match: /api/v1/payments/*
+    timeout: 2s
+    retries: 0
+    methods: [POST]
+    breaker:
+      threshold: 3
+      reset: 10s
+`
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	rules, err := cfg.routingRules()
+	if err != nil {
+		t.Fatalf("routingRules: %v", err)
+	}
+	if len(rules) != 1 {
+		t.Fatalf("expected 1 rule, got %d", len(rules))
+	}
+	rule := rules[0]
+	if rule.Name != "payments" || rule.Match != "/api/v1/payments/*" {
+		t.Fatalf("unexpected rule: %+v", rule)
+	}
+	if rule.Timeout != 2*time.Second {
+		t.Fatalf("expected timeout 2s, got %s", rule.Timeout)
+	}
+	if rule.Breaker.Threshold != 3 || rule.Breaker.Reset != 10*time.Second {
+		t.Fatalf("unexpected breaker config: %+v", rule.Breaker)
+	}
+}
+
+func TestLoadConfigParsesJSONRoutes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	body := `{"routes":[{"name":"catalog","match":"/api/v1/catalog/*","retries":2}]}`
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	rules, err := cfg.routingRules()
+	if err != nil {
+		t.Fatalf("routingRules: %v", err)
+	}
+	if len(rules) != 1 || rules[0].Retries != 2 {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+}
+
+func TestLoadConfigEmptyPathReturnsNoRoutes(t *testing.T) {
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if len(cfg.Routes) != 0 {
+		t.Fatalf("expected no routes, got %d", len(cfg.Routes))
+	}
+}
+
+func TestConfigRoutingRulesRejectsInvalidDuration(t *testing.T) {
+	cfg := &Config{Routes: []RouteConfig{{Name: "bad", Match: "/x/*", Timeout: "not-a-duration"}}}
+	if _, err := cfg.routingRules(); err == nil {
+		t.Fatal("expected an error for an invalid timeout duration")
+	}
+}
@@ -0,0 +1,146 @@
+// Package main: active upstream health probing. Complements the circuit
+// breaker's request-driven failure counting with a background prober that
+// can force a faster recovery (Open -> HalfOpen) or a pre-emptive trip
+// (Closed -> Open) independent of live traffic.
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var upstreamHealthy = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "edge_sidecar_upstream_healthy",
+		Help: "Whether the active health prober currently considers the upstream healthy (1) or not (0)",
+	},
+)
+
+// HealthProber periodically polls the upstream's health endpoint and caches
+// the result, so the sidecar's own /ready handler never issues a fresh
+// outbound request per probe - the cache is what keeps a high-frequency
+// Kubernetes prober from becoming an unbounded self-DoS.
+type HealthProber struct {
+	client             *http.Client
+	url                string
+	interval           time.Duration
+	healthyThreshold   int
+	unhealthyThreshold int
+	breaker            *CircuitBreaker
+
+	mutex           sync.RWMutex
+	healthy         bool
+	consecutiveOK   int
+	consecutiveFail int
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewHealthProber creates a prober targeting targetURL+path. It starts
+// optimistically healthy so a slow-starting upstream isn't immediately
+// flagged down before the first probe completes.
+func NewHealthProber(targetURL, path string, interval time.Duration, healthyThreshold, unhealthyThreshold int, breaker *CircuitBreaker) *HealthProber {
+	timeout := interval
+	if timeout > 5*time.Second {
+		timeout = 5 * time.Second
+	}
+	return &HealthProber{
+		client:             &http.Client{Timeout: timeout},
+		url:                strings.TrimRight(targetURL, "/") + path,
+		interval:           interval,
+		healthyThreshold:   healthyThreshold,
+		unhealthyThreshold: unhealthyThreshold,
+		breaker:            breaker,
+		healthy:            true,
+		stopCh:             make(chan struct{}),
+		doneCh:             make(chan struct{}),
+	}
+}
+
+// Start begins probing in a background goroutine.
+func (p *HealthProber) Start() {
+	upstreamHealthy.Set(1)
+	go p.run()
+}
+
+// Stop halts probing and waits for the background goroutine to exit.
+func (p *HealthProber) Stop() {
+	close(p.stopCh)
+	<-p.doneCh
+}
+
+// Healthy reports the most recently cached probe result.
+func (p *HealthProber) Healthy() bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.healthy
+}
+
+func (p *HealthProber) run() {
+	defer close(p.doneCh)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			p.probeOnce()
+		}
+	}
+}
+
+func (p *HealthProber) probeOnce() {
+	ok := p.probe()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if ok {
+		p.consecutiveFail = 0
+		p.consecutiveOK++
+		if p.consecutiveOK >= p.healthyThreshold {
+			if !p.healthy {
+				p.healthy = true
+				upstreamHealthy.Set(1)
+				log.Printf("Upstream healthy again after %d consecutive successful probes", p.consecutiveOK)
+			}
+			// Short-circuit resetTimeout: repeated probe success while
+			// Open promotes the breaker straight to HalfOpen.
+			if p.breaker.GetState() == StateOpen {
+				p.breaker.ForceHalfOpen()
+			}
+		}
+		return
+	}
+
+	p.consecutiveOK = 0
+	p.consecutiveFail++
+	if p.consecutiveFail >= p.unhealthyThreshold {
+		if p.healthy {
+			p.healthy = false
+			upstreamHealthy.Set(0)
+			log.Printf("Upstream unhealthy after %d consecutive failed probes", p.consecutiveFail)
+		}
+		// Pre-emptively open while Closed, ahead of request-driven failure
+		// counting.
+		p.breaker.ForceOpen()
+	}
+}
+
+func (p *HealthProber) probe() bool {
+	resp, err := p.client.Get(p.url)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode == http.StatusOK
+}
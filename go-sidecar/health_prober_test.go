@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthProberForcesHalfOpenOnRecovery(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(false)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if healthy.Load() {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer testServer.Close()
+
+	cb := NewCircuitBreaker(CircuitBreakerSettings{
+		Name:                        "test-prober-recovery",
+		ConsecutiveFailureThreshold: 1,
+		ResetTimeout:                time.Hour, // long enough that only the prober can promote it
+	})
+	cb.RecordFailure()
+	if cb.GetState() != StateOpen {
+		t.Fatal("Expected breaker to be open before probing starts")
+	}
+
+	prober := NewHealthProber(testServer.URL, "/healthz", 10*time.Millisecond, 2, 2, cb)
+	prober.Start()
+	defer prober.Stop()
+
+	healthy.Store(true)
+
+	deadline := time.After(time.Second)
+	for cb.GetState() != StateHalfOpen {
+		select {
+		case <-deadline:
+			t.Fatalf("Breaker never promoted to half-open, stuck at %s", cb.GetState())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func TestHealthProberPreemptivelyOpensOnFailure(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer testServer.Close()
+
+	cb := NewCircuitBreaker(CircuitBreakerSettings{
+		Name:                        "test-prober-preemptive",
+		ConsecutiveFailureThreshold: 1000, // request traffic alone shouldn't trip this
+		ResetTimeout:                time.Second,
+	})
+
+	prober := NewHealthProber(testServer.URL, "/healthz", 10*time.Millisecond, 2, 2, cb)
+	prober.Start()
+	defer prober.Stop()
+
+	deadline := time.After(time.Second)
+	for cb.GetState() != StateOpen {
+		select {
+		case <-deadline:
+			t.Fatalf("Breaker was never pre-emptively opened, stuck at %s", cb.GetState())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	if prober.Healthy() {
+		t.Fatal("Prober should report unhealthy after consecutive probe failures")
+	}
+}
+
+func TestHealthProberCachesStatusBetweenProbes(t *testing.T) {
+	var requestCount atomic.Int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	cb := NewCircuitBreaker(CircuitBreakerSettings{Name: "test-prober-cache", ConsecutiveFailureThreshold: 5, ResetTimeout: time.Second})
+	prober := NewHealthProber(testServer.URL, "/healthz", time.Hour, 1, 1, cb)
+	prober.Start()
+	defer prober.Stop()
+
+	// Hammer the cached accessor; it must never itself perform an HTTP call.
+	for i := 0; i < 1000; i++ {
+		prober.Healthy()
+	}
+	if n := requestCount.Load(); n > 1 {
+		t.Fatalf("Expected Healthy() to be served from cache, but upstream saw %d requests", n)
+	}
+}
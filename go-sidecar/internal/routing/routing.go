@@ -0,0 +1,171 @@
+// Package routing owns the mapping from an incoming request's path/method to
+// the per-route policy overrides (timeout, retry count, circuit breaker) that
+// should apply, so that a slow or flaky route doesn't have to share a global
+// circuit breaker and retry budget with every other route behind the
+// sidecar. A RouteRegistry's rule set can be swapped out atomically - e.g. on
+// a SIGHUP config reload - without ever leaving an in-flight Match call
+// observing a half-updated set of rules.
+package routing
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CircuitBreaker is the minimal surface RouteRegistry needs from a circuit
+// breaker implementation. It's satisfied by the sidecar's own *CircuitBreaker
+// without this package needing to import it (and thus without a dependency
+// cycle back into package main).
+type CircuitBreaker interface {
+	Allow() bool
+	RecordSuccess()
+	RecordFailure()
+}
+
+// CircuitBreakerFactory builds a named CircuitBreaker from a route's breaker
+// config. The caller supplies this so RouteRegistry never has to know how a
+// breaker is actually constructed or what its defaults are.
+type CircuitBreakerFactory func(name string, cfg BreakerConfig) CircuitBreaker
+
+// BreakerConfig is a route's circuit breaker overrides. A zero value for
+// either field means "use the caller's default".
+type BreakerConfig struct {
+	Threshold int
+	Reset     time.Duration
+}
+
+// Rule describes one route's overrides. Match is a path pattern; today it
+// supports an exact path or a prefix match via a single trailing "*" (e.g.
+// "/api/v1/payments/*"). A zero Timeout, zero Retries, or empty Methods means
+// "fall back to the registry's defaults / all methods".
+type Rule struct {
+	Name    string
+	Match   string
+	Timeout time.Duration
+	Retries int
+	Methods []string
+	Breaker BreakerConfig
+}
+
+// Resolved is the effective policy for a single request: the matched route's
+// name (or "default" if nothing matched) plus the timeout, retry count, and
+// circuit breaker to use.
+type Resolved struct {
+	RouteName string
+	Timeout   time.Duration
+	Retries   int
+	Breaker   CircuitBreaker
+}
+
+// compiledRule is a Rule paired with its pre-parsed matcher and constructed
+// breaker, so Match never has to do pattern-compilation or breaker
+// construction work per request.
+type compiledRule struct {
+	Rule
+	matches func(path string) bool
+	breaker CircuitBreaker
+}
+
+// RouteRegistry holds the current set of per-route rules and the defaults a
+// matched rule falls back to for a zero Timeout or Retries. The rule set is stored behind an
+// atomic.Pointer so Load can swap it in without a lock on the request path.
+type RouteRegistry struct {
+	rules atomic.Pointer[[]*compiledRule]
+
+	defaultTimeout time.Duration
+	defaultRetries int
+	breakerFactory CircuitBreakerFactory
+}
+
+// NewRouteRegistry creates an empty registry; until Load is called every
+// Match is unmatched and returns the zero-valued "default" Resolved.
+// defaultTimeout/defaultRetries fill in a matched rule's zero Timeout or
+// Retries. breakerFactory may be
+// nil, in which case every Resolved.Breaker is nil and callers should fall
+// back to their own global breaker.
+func NewRouteRegistry(defaultTimeout time.Duration, defaultRetries int, breakerFactory CircuitBreakerFactory) *RouteRegistry {
+	r := &RouteRegistry{
+		defaultTimeout: defaultTimeout,
+		defaultRetries: defaultRetries,
+		breakerFactory: breakerFactory,
+	}
+	empty := make([]*compiledRule, 0)
+	r.rules.Store(&empty)
+	return r
+}
+
+// Load compiles rules and atomically swaps them in as the registry's active
+// set. Requests already resolved against the previous set keep running
+// against the breaker/policy they were handed - Load never invalidates a
+// Resolved value returned by an earlier Match.
+func (r *RouteRegistry) Load(rules []Rule) error {
+	compiled := make([]*compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		matcher, err := compileMatcher(rule.Match)
+		if err != nil {
+			return fmt.Errorf("route %q: %w", rule.Name, err)
+		}
+		cr := &compiledRule{Rule: rule, matches: matcher}
+		if r.breakerFactory != nil {
+			cr.breaker = r.breakerFactory(rule.Name, rule.Breaker)
+		}
+		compiled = append(compiled, cr)
+	}
+	r.rules.Store(&compiled)
+	return nil
+}
+
+// Match returns the effective policy for method and path: the first rule
+// whose pattern and methods match, or a Resolved with RouteName "default",
+// zero Timeout/Retries and a nil Breaker if none do.
+func (r *RouteRegistry) Match(method, path string) Resolved {
+	for _, cr := range *r.rules.Load() {
+		if !cr.matches(path) {
+			continue
+		}
+		if len(cr.Methods) > 0 && !containsMethodFold(cr.Methods, method) {
+			continue
+		}
+
+		timeout := cr.Timeout
+		if timeout <= 0 {
+			timeout = r.defaultTimeout
+		}
+		retries := cr.Retries
+		if retries <= 0 {
+			retries = r.defaultRetries
+		}
+		return Resolved{RouteName: cr.Name, Timeout: timeout, Retries: retries, Breaker: cr.breaker}
+	}
+
+	// Zero Timeout/Retries here, not the registry's defaults - the caller
+	// (ProxyHandler.ServeHTTP) already falls back to its own global
+	// retryPolicy/timeout when Resolved reports <= 0, and baking the
+	// defaults in here a second time would make that fallback unreachable
+	// and any divergence between the two defaults silently lost.
+	return Resolved{RouteName: "default"}
+}
+
+func containsMethodFold(methods []string, method string) bool {
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileMatcher turns a match pattern into a matcher function. A trailing
+// "*" matches as a path prefix; anything else must match the path exactly.
+func compileMatcher(pattern string) (func(path string) bool, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("empty match pattern")
+	}
+	if strings.HasSuffix(pattern, "*") {
+		prefix := strings.TrimSuffix(pattern, "*")
+		return func(path string) bool { return strings.HasPrefix(path, prefix) }, nil
+	}
+	return func(path string) bool { return path == pattern }, nil
+}
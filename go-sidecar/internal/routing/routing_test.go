@@ -0,0 +1,115 @@
+package routing
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeBreaker struct {
+	failures int
+}
+
+func (f *fakeBreaker) Allow() bool    { return true }
+func (f *fakeBreaker) RecordSuccess() {}
+func (f *fakeBreaker) RecordFailure() { f.failures++ }
+
+func TestRouteRegistryMatchesPrefixPattern(t *testing.T) {
+	r := NewRouteRegistry(5*time.Second, 3, nil)
+	if err := r.Load([]Rule{
+		{Name: "payments", Match: "/api/v1/payments/*", Timeout: 2 * time.Second, Retries: 0, Methods: []string{"POST"}},
+	}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	resolved := r.Match("POST", "/api/v1/payments/charge")
+	if resolved.RouteName != "payments" {
+		t.Fatalf("expected route %q, got %q", "payments", resolved.RouteName)
+	}
+	if resolved.Timeout != 2*time.Second {
+		t.Fatalf("expected timeout 2s, got %s", resolved.Timeout)
+	}
+}
+
+func TestRouteRegistryFallsBackToDefaultsWhenUnmatched(t *testing.T) {
+	r := NewRouteRegistry(5*time.Second, 3, nil)
+	if err := r.Load([]Rule{
+		{Name: "payments", Match: "/api/v1/payments/*"},
+	}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	// An unmatched request gets a zero-valued Resolved, not the registry's
+	// own defaults baked in a second time - it's the caller's job (e.g.
+	// ProxyHandler.ServeHTTP) to fall back to its own timeout/retry policy
+	// when Timeout/Retries are <= 0.
+	resolved := r.Match("GET", "/api/v1/catalog")
+	if resolved.RouteName != "default" {
+		t.Fatalf("expected route %q, got %q", "default", resolved.RouteName)
+	}
+	if resolved.Timeout != 0 || resolved.Retries != 0 {
+		t.Fatalf("expected zero-valued fallback, got %+v", resolved)
+	}
+}
+
+func TestRouteRegistryMethodFilterExcludesOtherMethods(t *testing.T) {
+	r := NewRouteRegistry(time.Second, 1, nil)
+	if err := r.Load([]Rule{
+		{Name: "payments", Match: "/api/v1/payments/*", Methods: []string{"POST"}},
+	}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	resolved := r.Match("GET", "/api/v1/payments/charge")
+	if resolved.RouteName != "default" {
+		t.Fatalf("expected GET to fall through to default, got route %q", resolved.RouteName)
+	}
+}
+
+func TestRouteRegistryUsesBreakerFactoryPerRoute(t *testing.T) {
+	breakers := map[string]*fakeBreaker{}
+	factory := func(name string, cfg BreakerConfig) CircuitBreaker {
+		b := &fakeBreaker{}
+		breakers[name] = b
+		return b
+	}
+
+	r := NewRouteRegistry(time.Second, 1, factory)
+	if err := r.Load([]Rule{
+		{Name: "payments", Match: "/api/v1/payments/*", Breaker: BreakerConfig{Threshold: 3, Reset: 10 * time.Second}},
+	}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	resolved := r.Match("POST", "/api/v1/payments/charge")
+	if resolved.Breaker == nil {
+		t.Fatal("expected a per-route breaker, got nil")
+	}
+	resolved.Breaker.RecordFailure()
+	if breakers["payments"].failures != 1 {
+		t.Fatalf("expected the route's breaker to record the failure, got %d", breakers["payments"].failures)
+	}
+}
+
+func TestRouteRegistryLoadSwapIsAtomic(t *testing.T) {
+	r := NewRouteRegistry(time.Second, 1, nil)
+	if err := r.Load([]Rule{{Name: "v1", Match: "/api/*"}}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := r.Match("GET", "/api/anything").RouteName; got != "v1" {
+		t.Fatalf("expected route %q before reload, got %q", "v1", got)
+	}
+
+	if err := r.Load([]Rule{{Name: "v2", Match: "/api/*"}}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := r.Match("GET", "/api/anything").RouteName; got != "v2" {
+		t.Fatalf("expected route %q after reload, got %q", "v2", got)
+	}
+}
+
+func TestRouteRegistryRejectsEmptyMatchPattern(t *testing.T) {
+	r := NewRouteRegistry(time.Second, 1, nil)
+	if err := r.Load([]Rule{{Name: "broken", Match: ""}}); err == nil {
+		t.Fatal("expected an error for an empty match pattern")
+	}
+}
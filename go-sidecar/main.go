@@ -1,25 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
-	"fmt"
 	"io"
 	"log"
-	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
-	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/robert-nguyenn/edge_control_platform/go-sidecar/internal/routing"
 )
 
 var (
@@ -30,12 +30,45 @@ var (
 	writeTimeout   = flag.Duration("write-timeout", 10*time.Second, "HTTP write timeout")
 	maxIdleConns   = flag.Int("max-idle-conns", 100, "Maximum number of idle connections")
 	maxConnPerHost = flag.Int("max-conn-per-host", 100, "Maximum connections per host")
-	retryAttempts  = flag.Int("retry-attempts", 3, "Number of retry attempts for failed requests")
-	retryWait      = flag.Duration("retry-wait", 100*time.Millisecond, "Wait time between retries")
+
+	// Retry settings
+	retryAttempts            = flag.Int("retry-attempts", 3, "Number of retry attempts for failed requests")
+	retryInitialInterval     = flag.Duration("retry-initial-interval", 100*time.Millisecond, "Initial backoff interval between retries")
+	retryMaxInterval         = flag.Duration("retry-max-interval", 2*time.Second, "Maximum backoff interval between retries")
+	retryMultiplier          = flag.Float64("retry-multiplier", 1.5, "Backoff interval multiplier applied after each retry")
+	retryRandomizationFactor = flag.Float64("retry-randomization-factor", 0.5, "Jitter applied to each backoff interval, as a fraction of the interval")
+	retryMaxElapsedTime      = flag.Duration("retry-max-elapsed-time", 10*time.Second, "Give up retrying once this much time has elapsed for a request; 0 disables the limit")
+	retryOn429               = flag.Bool("retry-on-429", true, "Retry 429 Too Many Requests responses, honoring Retry-After")
+	retryMethods             = flag.String("retry-methods", "", "Comma-separated non-idempotent methods (e.g. POST,PATCH) to allow retrying, opt-in only")
 
 	// Circuit breaker settings
-	cbThreshold    = flag.Int("cb-threshold", 5, "Number of failures before circuit breaker opens")
-	cbResetTimeout = flag.Duration("cb-reset-timeout", 30*time.Second, "Time before circuit breaker resets")
+	cbThreshold           = flag.Int("cb-threshold", 5, "Number of consecutive failures before circuit breaker opens")
+	cbFailureRatio        = flag.Float64("cb-failure-ratio", 0, "Failure ratio (0-1) that opens the circuit breaker; 0 disables ratio-based tripping")
+	cbMinRequests         = flag.Uint("cb-min-requests", 10, "Minimum requests in the rolling window before cb-failure-ratio is evaluated")
+	cbResetTimeout        = flag.Duration("cb-reset-timeout", 30*time.Second, "Time the circuit breaker stays open before probing with a half-open request")
+	cbMaxHalfOpenRequests = flag.Uint("cb-max-half-open-requests", 1, "Number of probe requests allowed while the circuit breaker is half-open")
+
+	// Active health probing settings
+	probeInterval           = flag.Duration("probe-interval", 5*time.Second, "Interval between active upstream health probes")
+	probePath               = flag.String("probe-path", "/healthz", "Path appended to --target for active health probes")
+	probeHealthyThreshold   = flag.Int("probe-healthy-threshold", 2, "Consecutive successful probes required to mark the upstream healthy")
+	probeUnhealthyThreshold = flag.Int("probe-unhealthy-threshold", 3, "Consecutive failed probes required to mark the upstream unhealthy")
+
+	// Reload settings
+	reloadTimeout = flag.Duration("reload-timeout", 30*time.Second, "Deadline for the outgoing process to drain in-flight connections during a SIGHUP reload")
+	pidFile       = flag.String("pid-file", "edge-sidecar.pid", "File to record the PID of whichever process currently owns the listening socket; empty disables it")
+
+	// Per-route configuration. Reloaded for free on every SIGHUP, since a
+	// reload respawns the process and the respawned process re-reads this
+	// flag at startup.
+	configPath = flag.String("config", "", "Path to a YAML/JSON file of per-route timeout/retry/breaker overrides; empty disables per-route config")
+
+	// Concurrency limiter settings
+	maxInflight                = flag.Int("max-inflight", 0, "Maximum number of concurrent in-flight requests admitted to the upstream; 0 disables the limiter")
+	adaptiveConcurrency        = flag.Bool("adaptive-concurrency", false, "Adjust --max-inflight up/down (AIMD) based on rolling p95 latency vs. the observed minimum, instead of enforcing it as a fixed ceiling")
+	concurrencyMinLimit        = flag.Int("concurrency-min-limit", 1, "Lowest the adaptive concurrency limit will ever be multiplicatively decreased to")
+	concurrencyLatencyMultiple = flag.Float64("concurrency-latency-degradation-multiple", 2.0, "Rolling p95 latency, as a multiple of the observed minimum, that triggers a multiplicative decrease")
+	concurrencySampleWindow    = flag.Int("concurrency-sample-window", 100, "Number of recent request latencies the rolling p95 is computed over")
 
 	// Prometheus metrics
 	requestsTotal = prometheus.NewCounterVec(
@@ -43,7 +76,7 @@ var (
 			Name: "edge_sidecar_requests_total",
 			Help: "Total number of requests processed by the sidecar",
 		},
-		[]string{"method", "path", "status"},
+		[]string{"method", "path", "route", "status"},
 	)
 	requestDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -51,7 +84,7 @@ var (
 			Help:    "Request duration in seconds",
 			Buckets: prometheus.DefBuckets,
 		},
-		[]string{"method", "path"},
+		[]string{"method", "path", "route"},
 	)
 	retriesTotal = prometheus.NewCounter(
 		prometheus.CounterOpts{
@@ -59,175 +92,259 @@ var (
 			Help: "Total number of retry attempts",
 		},
 	)
-	circuitBreakerOpen = prometheus.NewGauge(
-		prometheus.GaugeOpts{
-			Name: "edge_sidecar_circuit_breaker_open",
-			Help: "Circuit breaker status (1 = open, 0 = closed)",
-		},
-	)
 )
 
-// Circuit breaker implementation
-type CircuitBreaker struct {
-	failureCount   int
-	lastFailure    time.Time
-	isOpen         bool
-	threshold      int
-	resetTimeout   time.Duration
-	mutex          sync.RWMutex
-	resetTimeoutCh chan struct{}
-}
-
-func NewCircuitBreaker(threshold int, resetTimeout time.Duration) *CircuitBreaker {
-	return &CircuitBreaker{
-		threshold:      threshold,
-		resetTimeout:   resetTimeout,
-		resetTimeoutCh: make(chan struct{}, 1),
-	}
-}
-
-func (cb *CircuitBreaker) Allow() bool {
-	cb.mutex.RLock()
-	defer cb.mutex.RUnlock()
-	return !cb.isOpen
-}
-
-func (cb *CircuitBreaker) RecordSuccess() {
-	cb.mutex.Lock()
-	defer cb.mutex.Unlock()
-
-	cb.failureCount = 0
-	if cb.isOpen {
-		cb.isOpen = false
-		circuitBreakerOpen.Set(0)
-		log.Println("Circuit breaker closed")
-	}
-}
-
-func (cb *CircuitBreaker) RecordFailure() {
-	cb.mutex.Lock()
-	defer cb.mutex.Unlock()
-
-	cb.failureCount++
-	cb.lastFailure = time.Now()
-
-	if cb.failureCount >= cb.threshold && !cb.isOpen {
-		cb.isOpen = true
-		circuitBreakerOpen.Set(1)
-		log.Printf("Circuit breaker opened after %d failures", cb.failureCount)
-
-		// Start reset timeout
-		select {
-		case cb.resetTimeoutCh <- struct{}{}:
-			go func() {
-				time.Sleep(cb.resetTimeout)
-				cb.mutex.Lock()
-				defer cb.mutex.Unlock()
-				cb.isOpen = false
-				cb.failureCount = 0
-				circuitBreakerOpen.Set(0)
-				log.Println("Circuit breaker reset after timeout")
-				<-cb.resetTimeoutCh
-			}()
-		default:
-			// Reset already scheduled
-		}
-	}
-}
-
 // ProxyHandler handles the reverse proxy with circuit breaker and retries
 type ProxyHandler struct {
-	target        *url.URL
-	proxy         *httputil.ReverseProxy
-	circuitBreaker *CircuitBreaker
+	target         *url.URL
+	proxy          *httputil.ReverseProxy
+	client         *http.Client
+	circuitBreaker routing.CircuitBreaker
+	retryPolicy    *RetryPolicy
+	routes         *routing.RouteRegistry
+	limiter        *ConcurrencyLimiter
 }
 
-func NewProxyHandler(targetURL string, cb *CircuitBreaker) (*ProxyHandler, error) {
+func NewProxyHandler(targetURL string, cb *CircuitBreaker, routes *routing.RouteRegistry, limiter *ConcurrencyLimiter) (*ProxyHandler, error) {
 	target, err := url.Parse(targetURL)
 	if err != nil {
 		return nil, err
 	}
 
 	proxy := httputil.NewSingleHostReverseProxy(target)
-	
+
 	// Customize the transport
 	defaultTransport := http.DefaultTransport.(*http.Transport).Clone()
 	defaultTransport.MaxIdleConns = *maxIdleConns
 	defaultTransport.MaxIdleConnsPerHost = *maxConnPerHost
-	
+
 	proxy.Transport = defaultTransport
 
-	// Customize error handler
+	// Customize error handler: classify the failure so client disconnects,
+	// timeouts, dial failures and mid-flight connection errors each get the
+	// right status code. Whether this counts against a circuit breaker is
+	// decided by the caller (ServeHTTP), since which breaker applies - the
+	// global one or a per-route one - isn't known here.
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("Proxy error: %v", err)
-		w.WriteHeader(http.StatusBadGateway)
-		w.Write([]byte("Service temporarily unavailable"))
+		class := ClassifyError(err, r.Context())
+		errorClassTotal.WithLabelValues(class.String()).Inc()
+
+		log.Printf("Proxy error (%s): %v", class, err)
+		w.WriteHeader(class.StatusCode())
+		w.Write([]byte(class.StatusText()))
 	}
 
 	return &ProxyHandler{
-		target:        target,
-		proxy:         proxy,
+		target: target,
+		proxy:  proxy,
+		client: &http.Client{
+			Transport: defaultTransport,
+			// We buffer and retry ourselves, so the client must never
+			// follow redirects on our behalf.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
 		circuitBreaker: cb,
+		retryPolicy: &RetryPolicy{
+			MaxAttempts:         *retryAttempts,
+			InitialInterval:     *retryInitialInterval,
+			MaxInterval:         *retryMaxInterval,
+			Multiplier:          *retryMultiplier,
+			RandomizationFactor: *retryRandomizationFactor,
+			MaxElapsedTime:      *retryMaxElapsedTime,
+			RetryOn429:          *retryOn429,
+			ExtraRetryMethods:   parseRetryMethods(*retryMethods),
+		},
+		routes:  routes,
+		limiter: limiter,
 	}, nil
 }
 
+// bufferedUpstreamResponse is an in-memory copy of an upstream response,
+// held until we know whether it should be retried or flushed to the client.
+type bufferedUpstreamResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+func (b *bufferedUpstreamResponse) flush(w http.ResponseWriter) {
+	dst := w.Header()
+	for k, vv := range b.header {
+		dst[k] = vv
+	}
+	w.WriteHeader(b.statusCode)
+	w.Write(b.body)
+}
+
+// cloneUpstreamRequest builds a fresh request to the target for one retry
+// attempt, replaying the buffered body and reusing the proxy's Director to
+// rewrite scheme/host/path exactly as httputil.ReverseProxy would.
+func (h *ProxyHandler) cloneUpstreamRequest(r *http.Request, body []byte) *http.Request {
+	req := r.Clone(r.Context())
+	req.RequestURI = "" // only valid on server requests; must be cleared before use as a client request
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	h.proxy.Director(req)
+	return req
+}
+
 func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	path := r.URL.Path
 	method := r.Method
-	
+
+	// Shed load before doing any other work if the concurrency limiter is
+	// saturated - this is meant to protect the upstream before it starts
+	// failing outright, so it takes priority over the circuit breaker and
+	// per-route resolution below.
+	if h.limiter != nil {
+		if !h.limiter.Acquire() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("Service temporarily unavailable - too many concurrent requests"))
+			requestsTotal.WithLabelValues(method, path, "unresolved", "503").Inc()
+			return
+		}
+		defer func() { h.limiter.Release(time.Since(start)) }()
+	}
+
+	// Resolve the per-route policy, falling back to the sidecar's global
+	// defaults for anything a route doesn't override.
+	resolved := h.routes.Match(method, path)
+	breaker := resolved.Breaker
+	if breaker == nil {
+		breaker = h.circuitBreaker
+	}
+	maxAttempts := resolved.Retries
+	if maxAttempts <= 0 {
+		maxAttempts = h.retryPolicy.MaxAttempts
+	}
+	if resolved.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), resolved.Timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
 	// Check circuit breaker
-	if !h.circuitBreaker.Allow() {
-		log.Printf("Circuit breaker open, rejecting request to %s", path)
+	if !breaker.Allow() {
+		log.Printf("Circuit breaker open, rejecting request to %s (route=%s)", path, resolved.RouteName)
 		w.WriteHeader(http.StatusServiceUnavailable)
 		w.Write([]byte("Service temporarily unavailable - circuit breaker open"))
-		requestsTotal.WithLabelValues(method, path, "503").Inc()
+		requestsTotal.WithLabelValues(method, path, resolved.RouteName, "503").Inc()
 		return
 	}
 
-	// Perform retries with exponential backoff
-	var resp *http.Response
-	var err error
-	var statusCode int
-	
-	success := false
-	
-	for attempt := 0; attempt <= *retryAttempts; attempt++ {
-		if attempt > 0 {
+	// Buffer the request body up front so it can be replayed on retry.
+	var bodyBytes []byte
+	if r.Body != nil {
+		var err error
+		bodyBytes, err = io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			requestsTotal.WithLabelValues(method, path, resolved.RouteName, "400").Inc()
+			return
+		}
+	}
+
+	canRetry := h.retryPolicy.Allowed(method)
+	backOff := h.retryPolicy.NewBackOff()
+
+	var buffered *bufferedUpstreamResponse
+	var upstreamErr error
+	statusCode := 0
+
+	for attempt := 0; ; attempt++ {
+		req := h.cloneUpstreamRequest(r, bodyBytes)
+
+		resp, err := h.client.Do(req)
+		if err != nil {
+			upstreamErr = err
+			buffered = nil
+
+			if !canRetry || attempt >= maxAttempts || !isRetryableTransportError(err, r.Context()) {
+				break
+			}
+			wait := backOff.NextBackOff()
+			if wait == Stop {
+				break
+			}
 			retriesTotal.Inc()
-			log.Printf("Retry attempt %d for %s %s", attempt, method, path)
-			time.Sleep(*retryWait * time.Duration(attempt))
+			log.Printf("Retry attempt %d for %s %s after error: %v", attempt+1, method, path, err)
+			time.Sleep(wait)
+			continue
 		}
-		
-		// Create a custom response writer to capture the status code
-		rw := &responseWriter{
-			ResponseWriter: w,
-			statusCode:     http.StatusOK,
+
+		buffered, err = readBufferedResponse(resp)
+		upstreamErr = nil
+		if err != nil {
+			upstreamErr = err
+			buffered = nil
+			break
 		}
 
-		// Proxy the request
-		h.proxy.ServeHTTP(rw, r)
-		
-		statusCode = rw.statusCode
-		
-		// Check if the request was successful (2xx or 3xx status)
-		if statusCode < 400 {
-			success = true
-			h.circuitBreaker.RecordSuccess()
+		if !canRetry || attempt >= maxAttempts || !isRetryableStatus(buffered.statusCode, h.retryPolicy.RetryOn429) {
 			break
 		}
-		
-		// If this was the last attempt and still failed, record a failure
-		if attempt == *retryAttempts {
-			h.circuitBreaker.RecordFailure()
+
+		wait := backOff.NextBackOff()
+		if wait == Stop {
+			break
+		}
+		if retryAfter, ok := retryAfterDuration(buffered.header); ok {
+			wait = retryAfter
+		}
+		retriesTotal.Inc()
+		log.Printf("Retry attempt %d for %s %s after status %d", attempt+1, method, path, buffered.statusCode)
+		time.Sleep(wait)
+	}
+
+	if upstreamErr != nil {
+		// ErrorHandler classifies the error and writes the response; we
+		// decide here whether that classification counts as a failure
+		// against whichever breaker (global or per-route) applies.
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusBadGateway}
+		h.proxy.ErrorHandler(rw, r, upstreamErr)
+		statusCode = rw.statusCode
+		if ClassifyError(upstreamErr, r.Context()).CountsAsUpstreamFailure() {
+			breaker.RecordFailure()
+		}
+	} else {
+		buffered.flush(w)
+		statusCode = buffered.statusCode
+		if statusCode < 500 {
+			breaker.RecordSuccess()
+		} else {
+			breaker.RecordFailure()
 		}
 	}
 
 	duration := time.Since(start).Seconds()
-	requestDuration.WithLabelValues(method, path).Observe(duration)
-	requestsTotal.WithLabelValues(method, path, strconv.Itoa(statusCode)).Inc()
-	
-	log.Printf("%s %s - %d - %.2fs", method, path, statusCode, duration)
+	requestDuration.WithLabelValues(method, path, resolved.RouteName).Observe(duration)
+	requestsTotal.WithLabelValues(method, path, resolved.RouteName, strconv.Itoa(statusCode)).Inc()
+
+	log.Printf("%s %s - %d - %.2fs (route=%s)", method, path, statusCode, duration, resolved.RouteName)
+}
+
+func readBufferedResponse(resp *http.Response) (*bufferedUpstreamResponse, error) {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &bufferedUpstreamResponse{
+		statusCode: resp.StatusCode,
+		header:     resp.Header.Clone(),
+		body:       body,
+	}, nil
+}
+
+// isRetryableTransportError reports whether client.Do's error represents a
+// connect-level failure worth retrying (FailureClassDialError). Client
+// cancellations, timeouts and mid-flight connection errors are not retried.
+func isRetryableTransportError(err error, reqCtx context.Context) bool {
+	return ClassifyError(err, reqCtx) == FailureClassDialError
 }
 
 // Custom ResponseWriter to capture status code
@@ -249,17 +366,81 @@ func main() {
 	flag.Parse()
 
 	// Register Prometheus metrics
-	prometheus.MustRegister(requestsTotal, requestDuration, retriesTotal, circuitBreakerOpen)
+	prometheus.MustRegister(requestsTotal, requestDuration, retriesTotal,
+		circuitBreakerState, circuitBreakerTransitions, errorClassTotal, upstreamHealthy,
+		inflightGauge, limitGauge, rejectedTotal)
 
 	// Create circuit breaker
-	cb := NewCircuitBreaker(*cbThreshold, *cbResetTimeout)
+	cb := NewCircuitBreaker(CircuitBreakerSettings{
+		Name:                        "upstream",
+		ConsecutiveFailureThreshold: *cbThreshold,
+		FailureRatio:                *cbFailureRatio,
+		MinRequests:                 uint32(*cbMinRequests),
+		ResetTimeout:                *cbResetTimeout,
+		MaxHalfOpenRequests:         uint32(*cbMaxHalfOpenRequests),
+		OnStateChange: func(name string, from, to State) {
+			log.Printf("Circuit breaker %q changed from %s to %s", name, from, to)
+		},
+	})
+
+	// Load per-route config and build the registry the proxy handler
+	// resolves each request's policy against. A route's breaker is built
+	// through NewCircuitBreaker like the global one, so it shows up
+	// alongside it in /breakers and shares the same Prometheus vectors.
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config %s: %v", *configPath, err)
+	}
+	rules, err := cfg.routingRules()
+	if err != nil {
+		log.Fatalf("Failed to parse routes in config %s: %v", *configPath, err)
+	}
+	routeRegistry := routing.NewRouteRegistry(*readTimeout, *retryAttempts, func(name string, bc routing.BreakerConfig) routing.CircuitBreaker {
+		threshold := bc.Threshold
+		if threshold <= 0 {
+			threshold = *cbThreshold
+		}
+		reset := bc.Reset
+		if reset <= 0 {
+			reset = *cbResetTimeout
+		}
+		return NewCircuitBreaker(CircuitBreakerSettings{
+			Name:                        name,
+			ConsecutiveFailureThreshold: threshold,
+			ResetTimeout:                reset,
+			MaxHalfOpenRequests:         uint32(*cbMaxHalfOpenRequests),
+			OnStateChange: func(name string, from, to State) {
+				log.Printf("Circuit breaker %q changed from %s to %s", name, from, to)
+			},
+		})
+	})
+	if err := routeRegistry.Load(rules); err != nil {
+		log.Fatalf("Failed to load routes from config %s: %v", *configPath, err)
+	}
+
+	// Build the concurrency limiter that shields the upstream from
+	// overload ahead of the circuit breaker; --max-inflight 0 disables it.
+	var limiter *ConcurrencyLimiter
+	if *maxInflight > 0 {
+		limiter = NewConcurrencyLimiter(ConcurrencyLimiterSettings{
+			MaxInflight:                *maxInflight,
+			Adaptive:                   *adaptiveConcurrency,
+			MinLimit:                   *concurrencyMinLimit,
+			LatencyDegradationMultiple: *concurrencyLatencyMultiple,
+			SampleWindow:               *concurrencySampleWindow,
+		})
+	}
 
 	// Create proxy handler
-	proxyHandler, err := NewProxyHandler(*targetHost, cb)
+	proxyHandler, err := NewProxyHandler(*targetHost, cb, routeRegistry, limiter)
 	if err != nil {
 		log.Fatalf("Failed to create proxy handler: %v", err)
 	}
 
+	// Start active health probing of the upstream
+	prober := NewHealthProber(*targetHost, *probePath, *probeInterval, *probeHealthyThreshold, *probeUnhealthyThreshold, cb)
+	prober.Start()
+
 	// Create router
 	router := mux.NewRouter()
 	
@@ -272,63 +453,101 @@ func main() {
 		w.Write([]byte(`{"status":"ok","version":"1.0.0"}`))
 	})
 	
-	// Add readiness probe that checks the target service
+	// Add readiness probe that reports the active health prober's cached
+	// status, rather than issuing a fresh upstream request per poll - at
+	// Kubernetes probe frequencies that would otherwise be an unbounded
+	// self-DoS against the upstream.
 	router.Path("/ready").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		targetURL := fmt.Sprintf("%s/healthz", *targetHost)
-		resp, err := http.Get(targetURL)
-		if err != nil || resp.StatusCode != http.StatusOK {
+		if !prober.Healthy() {
 			w.WriteHeader(http.StatusServiceUnavailable)
 			w.Write([]byte("Target service not ready"))
 			return
 		}
-		defer resp.Body.Close()
-		
+
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"status":"ready"}`))
 	})
 
+	// Add admin endpoint reporting circuit breaker state and counts
+	router.Path("/breakers").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(allBreakerStats()); err != nil {
+			log.Printf("Failed to encode breaker stats: %v", err)
+		}
+	})
+
 	// All other paths go to the proxy
 	router.PathPrefix("/").Handler(proxyHandler)
 
 	// Create server with timeouts
 	server := &http.Server{
-		Addr:         *listenAddr,
 		Handler:      router,
 		ReadTimeout:  *readTimeout,
 		WriteTimeout: *writeTimeout,
 	}
 
+	// Bind the listening socket ourselves (rather than via
+	// ListenAndServe) so that a SIGHUP reload can hand its file descriptor
+	// to a freshly exec'd child.
+	listener, err := createListener(*listenAddr)
+	if err != nil {
+		log.Fatalf("Failed to create listener: %v", err)
+	}
+
+	if err := writePIDFile(*pidFile, os.Getpid()); err != nil {
+		log.Printf("Failed to write pid file %s: %v", *pidFile, err)
+	}
+
 	// Channel to listen for errors coming from the listener.
 	serverErrors := make(chan error, 1)
-	
+
 	// Start the server
 	log.Printf("Starting sidecar proxy on %s -> %s", *listenAddr, *targetHost)
 	go func() {
-		serverErrors <- server.ListenAndServe()
+		serverErrors <- server.Serve(listener)
 	}()
 
-	// Set up graceful shutdown
-	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
-
-	// Block until receiving shutdown signal or server error
-	select {
-	case err := <-serverErrors:
-		log.Fatalf("Server error: %v", err)
-		
-	case sig := <-shutdown:
-		log.Printf("Shutdown signal received: %v", sig)
-		
-		// Give outstanding requests a deadline for completion
-		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-		defer cancel()
+	// Set up signal handling: SIGHUP triggers a zero-downtime reload,
+	// SIGINT/SIGTERM a plain graceful shutdown.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
-		// Gracefully shut down the server
-		if err := server.Shutdown(ctx); err != nil {
-			log.Printf("Graceful shutdown failed: %v", err)
-			if err := server.Close(); err != nil {
-				log.Printf("Error closing server: %v", err)
+	for {
+		select {
+		case err := <-serverErrors:
+			log.Fatalf("Server error: %v", err)
+
+		case sig := <-signals:
+			log.Printf("Signal received: %v", sig)
+
+			drainTimeout := 15 * time.Second
+			if sig == syscall.SIGHUP {
+				child, err := spawnReloadChild(listener)
+				if err != nil {
+					log.Printf("Reload failed, continuing to serve: %v", err)
+					continue
+				}
+				log.Printf("Spawned reload child with pid %d, draining existing connections", child.Pid)
+				if err := writePIDFile(*pidFile, child.Pid); err != nil {
+					log.Printf("Failed to update pid file %s: %v", *pidFile, err)
+				}
+				drainTimeout = *reloadTimeout
 			}
+
+			// Stop the health prober so it doesn't keep hitting an
+			// upstream we're about to stop routing to.
+			prober.Stop()
+
+			ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+			defer cancel()
+
+			if err := server.Shutdown(ctx); err != nil {
+				log.Printf("Graceful shutdown failed: %v", err)
+				if err := server.Close(); err != nil {
+					log.Printf("Error closing server: %v", err)
+				}
+			}
+			return
 		}
 	}
 }
@@ -1,41 +1,156 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/robert-nguyenn/edge_control_platform/go-sidecar/internal/routing"
 )
 
-func TestCircuitBreaker(t *testing.T) {
+func TestCircuitBreakerOpensAndResetsToHalfOpen(t *testing.T) {
 	threshold := 3
 	resetTimeout := 100 * time.Millisecond
-	
-	cb := NewCircuitBreaker(threshold, resetTimeout)
-	
+
+	cb := NewCircuitBreaker(CircuitBreakerSettings{
+		Name:                        "test-basic",
+		ConsecutiveFailureThreshold: threshold,
+		ResetTimeout:                resetTimeout,
+	})
+
 	// Circuit should start closed
 	if !cb.Allow() {
 		t.Fatal("Circuit breaker should start closed")
 	}
-	
+
 	// Record failures until threshold
 	for i := 0; i < threshold; i++ {
 		cb.RecordFailure()
 	}
-	
+
 	// Circuit should now be open
 	if cb.Allow() {
 		t.Fatal("Circuit breaker should be open after threshold failures")
 	}
-	
-	// Wait for reset
+
+	// Wait for reset timeout; the next Allow() should promote to half-open
 	time.Sleep(resetTimeout + 10*time.Millisecond)
-	
-	// Circuit should be closed again
+
+	if !cb.Allow() {
+		t.Fatal("Circuit breaker should admit a half-open probe after resetTimeout")
+	}
+	if state := cb.GetState(); state != StateHalfOpen {
+		t.Fatalf("Circuit breaker should be half-open, got %s", state)
+	}
+}
+
+func TestCircuitBreakerHalfOpenClosesOnSuccess(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerSettings{
+		Name:                        "test-half-open-success",
+		ConsecutiveFailureThreshold: 1,
+		ResetTimeout:                10 * time.Millisecond,
+		MaxHalfOpenRequests:         1,
+	})
+
+	cb.RecordFailure()
+	time.Sleep(20 * time.Millisecond)
+
+	if !cb.Allow() {
+		t.Fatal("Expected half-open probe to be allowed")
+	}
+	cb.RecordSuccess()
+
+	if state := cb.GetState(); state != StateClosed {
+		t.Fatalf("Circuit breaker should close after a successful half-open probe, got %s", state)
+	}
+}
+
+func TestCircuitBreakerHalfOpenReopensOnFailure(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerSettings{
+		Name:                        "test-half-open-failure",
+		ConsecutiveFailureThreshold: 1,
+		ResetTimeout:                10 * time.Millisecond,
+		MaxHalfOpenRequests:         1,
+	})
+
+	cb.RecordFailure()
+	time.Sleep(20 * time.Millisecond)
+
 	if !cb.Allow() {
-		t.Fatal("Circuit breaker should reset after timeout")
+		t.Fatal("Expected half-open probe to be allowed")
+	}
+	cb.RecordFailure()
+
+	if state := cb.GetState(); state != StateOpen {
+		t.Fatalf("A single half-open failure should re-open the breaker, got %s", state)
+	}
+	if cb.Allow() {
+		t.Fatal("Breaker should reject requests immediately after re-opening")
+	}
+}
+
+func TestCircuitBreakerHalfOpenLimitsInFlightProbes(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerSettings{
+		Name:                        "test-half-open-limit",
+		ConsecutiveFailureThreshold: 1,
+		ResetTimeout:                10 * time.Millisecond,
+		MaxHalfOpenRequests:         1,
+	})
+
+	cb.RecordFailure()
+	time.Sleep(20 * time.Millisecond)
+
+	if !cb.Allow() {
+		t.Fatal("Expected first half-open probe to be allowed")
+	}
+	if cb.Allow() {
+		t.Fatal("Expected second concurrent half-open probe to be rejected")
+	}
+}
+
+func TestCircuitBreakerFailureRatioTrips(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerSettings{
+		Name:         "test-ratio",
+		FailureRatio: 0.5,
+		MinRequests:  4,
+		ResetTimeout: time.Second,
+	})
+
+	cb.RecordSuccess()
+	cb.RecordSuccess()
+	if cb.GetState() != StateClosed {
+		t.Fatal("Breaker should still be closed below MinRequests")
+	}
+
+	cb.RecordFailure()
+	cb.RecordFailure()
+
+	if cb.GetState() != StateOpen {
+		t.Fatal("Breaker should open once the failure ratio threshold is reached")
+	}
+}
+
+func TestCircuitBreakerOnStateChangeHook(t *testing.T) {
+	var transitions []string
+	cb := NewCircuitBreaker(CircuitBreakerSettings{
+		Name:                        "test-hook",
+		ConsecutiveFailureThreshold: 1,
+		ResetTimeout:                time.Second,
+		OnStateChange: func(name string, from, to State) {
+			transitions = append(transitions, fmt.Sprintf("%s:%s->%s", name, from, to))
+		},
+	})
+
+	cb.RecordFailure()
+
+	if len(transitions) != 1 || transitions[0] != "test-hook:closed->open" {
+		t.Fatalf("Expected one closed->open transition, got %v", transitions)
 	}
 }
 
@@ -46,31 +161,262 @@ func TestProxyHandler(t *testing.T) {
 		w.Write([]byte("OK"))
 	}))
 	defer testServer.Close()
-	
+
 	// Create circuit breaker
-	cb := NewCircuitBreaker(3, 100*time.Millisecond)
-	
+	cb := NewCircuitBreaker(CircuitBreakerSettings{
+		Name:                        "test-proxy",
+		ConsecutiveFailureThreshold: 3,
+		ResetTimeout:                100 * time.Millisecond,
+	})
+
 	// Create proxy handler
-	handler, err := NewProxyHandler(testServer.URL, cb)
+	handler, err := NewProxyHandler(testServer.URL, cb, routing.NewRouteRegistry(time.Second, 1, nil), nil)
 	if err != nil {
 		t.Fatalf("Failed to create proxy handler: %v", err)
 	}
-	
+
 	// Create a test request
 	req := httptest.NewRequest("GET", "/test", nil)
 	recorder := httptest.NewRecorder()
-	
+
 	// Test the proxy
 	handler.ServeHTTP(recorder, req)
-	
+
 	// Check the response
 	if recorder.Code != http.StatusOK {
 		t.Fatalf("Expected status code %d, got %d", http.StatusOK, recorder.Code)
 	}
-	
+
 	if recorder.Body.String() != "OK" {
 		t.Fatalf("Expected body %q, got %q", "OK", recorder.Body.String())
 	}
-	
-	fmt.Println("All tests passed!")
+}
+
+// newTestProxyHandler builds a ProxyHandler against testServer with a fast
+// retry policy, suitable for exercising the retry loop in tests.
+func newTestProxyHandler(t *testing.T, targetURL string) *ProxyHandler {
+	t.Helper()
+	cb := NewCircuitBreaker(CircuitBreakerSettings{
+		Name:                        "test-retry-" + t.Name(),
+		ConsecutiveFailureThreshold: 100,
+		ResetTimeout:                time.Second,
+	})
+	handler, err := NewProxyHandler(targetURL, cb, routing.NewRouteRegistry(time.Second, 1, nil), nil)
+	if err != nil {
+		t.Fatalf("Failed to create proxy handler: %v", err)
+	}
+	handler.retryPolicy = &RetryPolicy{
+		MaxAttempts:         3,
+		InitialInterval:     5 * time.Millisecond,
+		MaxInterval:         20 * time.Millisecond,
+		Multiplier:          2,
+		RandomizationFactor: 0.5,
+		MaxElapsedTime:      time.Second,
+		RetryOn429:          true,
+		ExtraRetryMethods:   map[string]bool{},
+	}
+	return handler
+}
+
+func TestProxyHandlerRetriesOnRetryableStatusAndFlushesOnce(t *testing.T) {
+	var requestCount int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requestCount, 1)
+		if n < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}))
+	defer testServer.Close()
+
+	handler := newTestProxyHandler(t, testServer.URL)
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if got := atomic.LoadInt32(&requestCount); got != 3 {
+		t.Fatalf("Expected 3 upstream attempts, got %d", got)
+	}
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected client to see the final 200, got %d", recorder.Code)
+	}
+	if recorder.Body.String() != "OK" {
+		t.Fatalf("Expected exactly the final response body, got %q", recorder.Body.String())
+	}
+}
+
+func TestProxyHandlerDoesNotRetryNonIdempotentMethodByDefault(t *testing.T) {
+	var requestCount int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requestCount, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer testServer.Close()
+
+	handler := newTestProxyHandler(t, testServer.URL)
+
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("payload"))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if got := atomic.LoadInt32(&requestCount); got != 1 {
+		t.Fatalf("Expected exactly 1 attempt for a non-idempotent method, got %d", got)
+	}
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the single 503 to be passed through, got %d", recorder.Code)
+	}
+}
+
+func TestProxyHandlerReplaysRequestBodyOnRetry(t *testing.T) {
+	var requestCount int32
+	var bodies []string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		n := atomic.AddInt32(&requestCount, 1)
+		if n < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	handler := newTestProxyHandler(t, testServer.URL)
+
+	req := httptest.NewRequest(http.MethodPut, "/test", strings.NewReader("same-body"))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if len(bodies) != 2 || bodies[0] != "same-body" || bodies[1] != "same-body" {
+		t.Fatalf("Expected the buffered body to be replayed unchanged on retry, got %v", bodies)
+	}
+}
+
+func TestProxyHandlerHonorsRetryAfterHeader(t *testing.T) {
+	var requestCount int32
+	var secondAttemptAt time.Time
+	start := time.Now()
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requestCount, 1)
+		if n < 2 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		secondAttemptAt = time.Now()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	handler := newTestProxyHandler(t, testServer.URL)
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected eventual 200, got %d", recorder.Code)
+	}
+	if secondAttemptAt.Sub(start) < 900*time.Millisecond {
+		t.Fatalf("Expected Retry-After: 1 to be honored, retried after only %v", secondAttemptAt.Sub(start))
+	}
+}
+
+func TestExponentialBackOffJitterStaysWithinBounds(t *testing.T) {
+	b := NewExponentialBackOff(100*time.Millisecond, time.Second, 2, 0.5, time.Minute)
+
+	for i := 0; i < 20; i++ {
+		expected := b.currentInterval
+		delay := b.NextBackOff()
+		minDelay := time.Duration(float64(expected) * 0.5)
+		maxDelay := time.Duration(float64(expected) * 1.5)
+		if delay < minDelay || delay > maxDelay {
+			t.Fatalf("Delay %v outside jitter bounds [%v, %v] for base %v", delay, minDelay, maxDelay, expected)
+		}
+	}
+}
+
+func TestExponentialBackOffStopsAfterMaxElapsedTime(t *testing.T) {
+	b := NewExponentialBackOff(10*time.Millisecond, 10*time.Millisecond, 1, 0, 20*time.Millisecond)
+	time.Sleep(25 * time.Millisecond)
+	if got := b.NextBackOff(); got != Stop {
+		t.Fatalf("Expected Stop once MaxElapsedTime has elapsed, got %v", got)
+	}
+}
+
+func TestClassifyErrorClientCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	class := ClassifyError(context.Canceled, ctx)
+	if class != FailureClassClientCanceled {
+		t.Fatalf("Expected FailureClassClientCanceled, got %s", class)
+	}
+	if class.StatusCode() != statusClosedRequest {
+		t.Fatalf("Expected status %d, got %d", statusClosedRequest, class.StatusCode())
+	}
+	if class.CountsAsUpstreamFailure() {
+		t.Fatal("Client cancellation must not count as an upstream failure")
+	}
+}
+
+func TestClassifyErrorDeadlineExceeded(t *testing.T) {
+	class := ClassifyError(context.DeadlineExceeded, context.Background())
+	if class != FailureClassTimeout {
+		t.Fatalf("Expected FailureClassTimeout, got %s", class)
+	}
+	if class.StatusCode() != http.StatusGatewayTimeout {
+		t.Fatalf("Expected 504, got %d", class.StatusCode())
+	}
+	if !class.CountsAsUpstreamFailure() {
+		t.Fatal("A timeout should count as an upstream failure")
+	}
+}
+
+func TestClassifyErrorConnectionErrorDefaultsTo502(t *testing.T) {
+	class := ClassifyError(io.EOF, context.Background())
+	if class != FailureClassConnectionError {
+		t.Fatalf("Expected FailureClassConnectionError, got %s", class)
+	}
+	if class.StatusCode() != http.StatusBadGateway {
+		t.Fatalf("Expected 502, got %d", class.StatusCode())
+	}
+	if !class.CountsAsUpstreamFailure() {
+		t.Fatal("A connection error should count as an upstream failure")
+	}
+}
+
+func TestProxyHandlerClientCancellationDoesNotTripBreaker(t *testing.T) {
+	blockUntilCanceled := make(chan struct{})
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-blockUntilCanceled
+	}))
+	defer testServer.Close()
+	defer close(blockUntilCanceled)
+
+	handler := newTestProxyHandler(t, testServer.URL)
+	handler.retryPolicy.MaxAttempts = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/test", nil).WithContext(ctx)
+	recorder := httptest.NewRecorder()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != statusClosedRequest {
+		t.Fatalf("Expected %d Client Closed Request, got %d", statusClosedRequest, recorder.Code)
+	}
+	stats := handler.circuitBreaker.(*CircuitBreaker).GetStats()
+	if stats.TotalFailures != 0 {
+		t.Fatalf("Client cancellation must not call RecordFailure, got %d total failures", stats.TotalFailures)
+	}
 }
@@ -1,41 +1,80 @@
+// Package main: zero-downtime reload via SIGHUP socket-inheritance. The
+// process currently listening hands its bound socket to a freshly exec'd
+// copy of itself, drains its in-flight connections, then exits - the new
+// process picks up accepting on the same socket without a single refused
+// connection.
 package main
 
 import (
+	"fmt"
 	"log"
+	"net"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
+	"os/exec"
+	"strconv"
 )
 
-func main() {
-	log.Println("Starting graceful reload monitor...")
-
-	// Set up signal handling
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
-
-	// Monitor for signals
-	for {
-		sig := <-sigs
-		log.Printf("Received signal: %v", sig)
-
-		switch sig {
-		case syscall.SIGHUP:
-			// SIGHUP is used for graceful reload
-			log.Println("Initiating graceful reload...")
-			
-			// In a real implementation, this would spawn a new process
-			// and gracefully transfer connections. For now we'll just simulate it.
-			go func() {
-				log.Println("Simulating reload process...")
-				time.Sleep(2 * time.Second)
-				log.Println("Reload completed successfully")
-			}()
-
-		case syscall.SIGTERM, syscall.SIGINT:
-			log.Println("Shutdown signal received, exiting...")
-			return
-		}
+// listenFDEnvVar, when set in a child's environment, names the file
+// descriptor (relative to ExtraFiles, so 3 is the first) the child should
+// accept on instead of binding a fresh listener.
+const listenFDEnvVar = "EDGE_SIDECAR_LISTEN_FD"
+
+// createListener binds listenAddr, or - if this process was exec'd by a
+// parent handing off its socket during a reload - inherits the already-bound
+// listener named by EDGE_SIDECAR_LISTEN_FD.
+func createListener(listenAddr string) (net.Listener, error) {
+	fdStr := os.Getenv(listenFDEnvVar)
+	if fdStr == "" {
+		return net.Listen("tcp", listenAddr)
+	}
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s=%q: %w", listenFDEnvVar, fdStr, err)
+	}
+	file := os.NewFile(uintptr(fd), "inherited-listener")
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("inheriting listener from fd %d: %w", fd, err)
+	}
+	file.Close() // net.FileListener dups the fd; close our copy
+	log.Printf("Inherited listener on fd %d from parent", fd)
+	return ln, nil
+}
+
+// spawnReloadChild execs a fresh copy of this binary, passing it the
+// currently bound listener via ExtraFiles so it can start accepting
+// connections on the same socket before this process stops accepting.
+func spawnReloadChild(ln net.Listener) (*os.Process, error) {
+	tcpLn, ok := ln.(*net.TCPListener)
+	if !ok {
+		return nil, fmt.Errorf("listener of type %T does not support fd handoff", ln)
+	}
+	lnFile, err := tcpLn.File()
+	if err != nil {
+		return nil, fmt.Errorf("getting listener fd: %w", err)
+	}
+	defer lnFile.Close()
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=3", listenFDEnvVar))
+	cmd.ExtraFiles = []*os.File{lnFile}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting reload child: %w", err)
+	}
+	return cmd.Process, nil
+}
+
+// writePIDFile records pid at path, overwriting any existing contents. It's
+// called both at startup and after a successful reload handoff, so the pid
+// file always names whichever process currently owns the listening socket.
+// An empty path disables the pid file.
+func writePIDFile(path string, pid int) error {
+	if path == "" {
+		return nil
 	}
+	return os.WriteFile(path, []byte(strconv.Itoa(pid)+"\n"), 0644)
 }
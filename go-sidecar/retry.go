@@ -0,0 +1,168 @@
+// Package main: retry policy for the proxy's upstream calls - exponential
+// backoff with jitter, Retry-After honoring, and idempotency-aware retry
+// eligibility.
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stop is returned by ExponentialBackOff.NextBackOff once MaxElapsedTime has
+// elapsed and no further retries should be attempted.
+const Stop time.Duration = -1
+
+// ExponentialBackOff generates successively longer retry delays with
+// jitter, in the style of cenkalti/backoff's ExponentialBackOff.
+type ExponentialBackOff struct {
+	InitialInterval     time.Duration
+	MaxInterval         time.Duration
+	Multiplier          float64
+	RandomizationFactor float64
+	MaxElapsedTime      time.Duration
+
+	currentInterval time.Duration
+	startTime       time.Time
+}
+
+// NewExponentialBackOff creates a ready-to-use backoff policy.
+func NewExponentialBackOff(initial, max time.Duration, multiplier, randomizationFactor float64, maxElapsed time.Duration) *ExponentialBackOff {
+	b := &ExponentialBackOff{
+		InitialInterval:     initial,
+		MaxInterval:         max,
+		Multiplier:          multiplier,
+		RandomizationFactor: randomizationFactor,
+		MaxElapsedTime:      maxElapsed,
+	}
+	b.Reset()
+	return b
+}
+
+// Reset restarts the backoff policy at InitialInterval.
+func (b *ExponentialBackOff) Reset() {
+	b.currentInterval = b.InitialInterval
+	b.startTime = time.Now()
+}
+
+// NextBackOff returns the delay before the next retry, or Stop if
+// MaxElapsedTime has elapsed.
+func (b *ExponentialBackOff) NextBackOff() time.Duration {
+	if b.MaxElapsedTime > 0 && time.Since(b.startTime) > b.MaxElapsedTime {
+		return Stop
+	}
+	delay := jitter(b.currentInterval, b.RandomizationFactor)
+	b.incrementInterval()
+	return delay
+}
+
+func (b *ExponentialBackOff) incrementInterval() {
+	if b.currentInterval >= b.MaxInterval {
+		b.currentInterval = b.MaxInterval
+		return
+	}
+	next := float64(b.currentInterval) * b.Multiplier
+	if next > float64(b.MaxInterval) {
+		next = float64(b.MaxInterval)
+	}
+	b.currentInterval = time.Duration(next)
+}
+
+func jitter(interval time.Duration, randomizationFactor float64) time.Duration {
+	if randomizationFactor <= 0 || interval <= 0 {
+		return interval
+	}
+	delta := randomizationFactor * float64(interval)
+	min := float64(interval) - delta
+	max := float64(interval) + delta
+	return time.Duration(min + rand.Float64()*(max-min))
+}
+
+// retryAfterDuration parses a Retry-After header (either delta-seconds or
+// an HTTP-date) and reports whether one was present.
+func retryAfterDuration(h http.Header) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if when, err := http.ParseTime(v); err == nil {
+		if d := time.Until(when); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// isRetryableStatus reports whether a response status code warrants a
+// retry. 4xx client errors (other than optionally 429) are excluded, since
+// resending the same request to the same upstream won't change the
+// outcome.
+func isRetryableStatus(statusCode int, retryOn429 bool) bool {
+	switch statusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusTooManyRequests:
+		return retryOn429
+	default:
+		return false
+	}
+}
+
+// defaultIdempotentMethods are safe to retry without the caller opting in,
+// per RFC 7231 idempotency.
+var defaultIdempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// parseRetryMethods turns a comma-separated --retry-methods flag value into
+// a set of additionally-retryable (non-idempotent) HTTP methods.
+func parseRetryMethods(csv string) map[string]bool {
+	methods := make(map[string]bool)
+	for _, m := range strings.Split(csv, ",") {
+		m = strings.ToUpper(strings.TrimSpace(m))
+		if m != "" {
+			methods[m] = true
+		}
+	}
+	return methods
+}
+
+// RetryPolicy decides whether a request is eligible for retries at all, and
+// builds the backoff schedule used once it's in flight.
+type RetryPolicy struct {
+	MaxAttempts         int
+	InitialInterval     time.Duration
+	MaxInterval         time.Duration
+	Multiplier          float64
+	RandomizationFactor float64
+	MaxElapsedTime      time.Duration
+	RetryOn429          bool
+	ExtraRetryMethods   map[string]bool
+}
+
+// Allowed reports whether method is eligible for retries under this policy.
+func (p *RetryPolicy) Allowed(method string) bool {
+	if defaultIdempotentMethods[method] {
+		return true
+	}
+	return p.ExtraRetryMethods[method]
+}
+
+// NewBackOff builds a fresh backoff schedule for a single request.
+func (p *RetryPolicy) NewBackOff() *ExponentialBackOff {
+	return NewExponentialBackOff(p.InitialInterval, p.MaxInterval, p.Multiplier, p.RandomizationFactor, p.MaxElapsedTime)
+}